@@ -1,118 +1,627 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/its-ernest/opentrace/installer"
 	"github.com/its-ernest/opentrace/sdk"
-	"gopkg.in/yaml.v3"
 )
 
+// maxParallel bounds how many steps run at once, regardless of how wide
+// the graph fans out. A foreach step holds one of those slots while it runs
+// up to maxParallel invocations of its module under a separate limit.
+const maxParallel = 8
+
 type Step struct {
-	Name   string         `yaml:"name"`
-	Input  string         `yaml:"input"`
-	Config map[string]any `yaml:"config"`
+	Name    string         `yaml:"name"`
+	Input   string         `yaml:"input"`
+	Inputs  []string       `yaml:"inputs"`
+	When    string         `yaml:"when"`
+	ForEach string         `yaml:"foreach"` // $parent — run once per entity the parent emits (filtered to Consumes), results merged
+	Config  map[string]any `yaml:"config"`
 }
 
 type Pipeline struct {
-	Modules []Step `yaml:"modules"`
+	Modules  []Step            `yaml:"modules"`
+	Requires map[string]string `yaml:"requires"` // module version constraints, e.g. {ip_locator: "^1.2"}
 }
 
-func Load(path string) (*Pipeline, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read pipeline %q: %w", path, err)
+// Output is the recorded result of running a single step, kept around so
+// later steps can reference specific fields via $step.field instead of
+// only the raw result string.
+type Output struct {
+	Result   string         // raw stdout result string (sdk.Output.Result)
+	JSON     map[string]any // Result re-parsed as a JSON object, if it was one
+	Entities []sdk.Entity   // structured entities the module emitted, across every NDJSON line it wrote
+	Duration time.Duration
+	ExitCode int
+}
+
+// node is one step placed in the dependency graph built from its $name
+// references.
+type node struct {
+	step    Step
+	parents []string
+	done    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	output Output
+	err    error
+	skip   bool
+}
+
+// Run executes the pipeline as a DAG: a step becomes eligible to run as
+// soon as every step it references has finished, so independent branches
+// run concurrently instead of waiting on each other. A step whose
+// dependencies failed (or whose `when:` evaluates false) is skipped rather
+// than aborting the run, and every step's outcome is reported in the
+// returned map instead of stopping at the first non-zero exit.
+func Run(ctx context.Context, p *Pipeline, binDir string) map[string]error {
+	if err := installer.CheckRequires(p.Requires); err != nil {
+		return map[string]error{"requires": err}
+	}
+	if err := ValidateWiring(p); err != nil {
+		return map[string]error{"wiring": err}
 	}
-	var p Pipeline
-	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &p); err != nil {
-		return nil, fmt.Errorf("invalid pipeline YAML: %w", err)
+	if err := ValidateAcyclic(p); err != nil {
+		return map[string]error{"wiring": err}
+	}
+
+	nodes := make(map[string]*node, len(p.Modules))
+	for _, step := range p.Modules {
+		nodes[step.Name] = &node{step: step, parents: refs(step), done: make(chan struct{})}
+	}
+	assignContexts(ctx, nodes)
+	defer func() {
+		for _, n := range nodes {
+			n.cancel()
+		}
+	}()
+
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			defer close(n.done)
+
+			for _, parent := range n.parents {
+				pn, ok := nodes[parent]
+				if !ok {
+					n.err = fmt.Errorf("module %q references %q but no such step exists", n.step.Name, parent)
+					return
+				}
+				<-pn.done
+				if pn.err != nil || pn.skip {
+					n.skip = true
+					n.err = fmt.Errorf("skipped: dependency %q did not succeed", parent)
+					return
+				}
+			}
+
+			if n.step.When != "" {
+				ok, err := evalWhen(n.step.When, nodes)
+				if err != nil {
+					n.err = fmt.Errorf("when: %w", err)
+					return
+				}
+				if !ok {
+					n.skip = true
+					return
+				}
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var out Output
+			var err error
+			if n.step.ForEach != "" {
+				out, err = runForEach(n.ctx, binDir, n.step, nodes)
+			} else {
+				var in sdk.Input
+				in, err = buildInput(n.step, nodes)
+				if err == nil {
+					out, err = runModule(n.ctx, filepath.Join(binDir, n.step.Name), in)
+				}
+			}
+			if err != nil {
+				n.err = fmt.Errorf("[%s] %w", n.step.Name, err)
+				n.cancel() // stop this node's own in-flight subprocess; dependents see n.err and skip without starting
+				return
+			}
+			n.output = out
+		}(n)
 	}
-	if len(p.Modules) == 0 {
-		return nil, fmt.Errorf("pipeline has no modules")
+
+	wg.Wait()
+
+	errs := make(map[string]error, len(nodes))
+	for name, n := range nodes {
+		errs[name] = n.err
 	}
-	return &p, nil
+	return errs
 }
 
-func Run(ctx context.Context, p *Pipeline, binDir string) error {
-	// outputs holds each module's result string, keyed by module name
-	outputs := make(map[string]string)
+// assignContexts gives every node its own context derived from its parents',
+// so canceling a node on failure only tears down subprocesses that
+// transitively depend on it rather than the whole run's shared context. A
+// root node (no parents) derives straight from ctx.
+func assignContexts(ctx context.Context, nodes map[string]*node) {
+	var assign func(n *node)
+	assign = func(n *node) {
+		if n.ctx != nil {
+			return
+		}
+		var parents []context.Context
+		for _, name := range n.parents {
+			if pn, ok := nodes[name]; ok {
+				assign(pn)
+				parents = append(parents, pn.ctx)
+			}
+		}
+		if len(parents) == 0 {
+			n.ctx, n.cancel = context.WithCancel(ctx)
+			return
+		}
+		n.ctx, n.cancel = mergeContexts(ctx, parents)
+	}
+	for _, n := range nodes {
+		assign(n)
+	}
+}
 
-	for _, step := range p.Modules {
-		// Resolve input — if starts with $ it's a reference to a prior output
-		input := step.Input
-		if strings.HasPrefix(input, "$") {
-			ref := strings.TrimPrefix(input, "$")
-			val, ok := outputs[ref]
-			if !ok {
-				return fmt.Errorf("module %q references output of %q but it hasn't run yet", step.Name, ref)
+// mergeContexts returns a context derived from ctx that is also canceled as
+// soon as any of parents is — so a node inherits cancellation from every
+// ancestor, however many steps up the failure happened.
+func mergeContexts(ctx context.Context, parents []context.Context) (context.Context, context.CancelFunc) {
+	child, cancel := context.WithCancel(ctx)
+	for _, p := range parents {
+		go func(p context.Context) {
+			select {
+			case <-p.Done():
+				cancel()
+			case <-child.Done():
 			}
-			input = val
+		}(p)
+	}
+	return child, cancel
+}
+
+// refs returns the step names a step's input(s) and when-expression point to.
+func refs(step Step) []string {
+	var out []string
+	add := func(s string) {
+		s = strings.TrimSpace(s)
+		if name, ok := strings.CutPrefix(s, "$"); ok {
+			name, _, _ = strings.Cut(name, ".")
+			out = append(out, name)
+		}
+	}
+	add(step.Input)
+	for _, in := range step.Inputs {
+		add(in)
+	}
+	add(step.ForEach)
+	for _, tok := range strings.Fields(step.When) {
+		add(tok)
+	}
+	return out
+}
+
+// buildInput resolves a step's input(s) against its parents' outputs. A
+// single `input:` becomes the raw string passed to the module, unchanged
+// from before; an `inputs:` list is resolved into a JSON object keyed by
+// source step name so a module can fan-in several upstream results.
+func buildInput(step Step, nodes map[string]*node) (sdk.Input, error) {
+	in := sdk.Input{Config: step.Config}
+	consumes := consumesFor(step.Name)
+
+	if step.Input != "" {
+		v, err := resolveRef(step.Input, nodes)
+		if err != nil {
+			return in, fmt.Errorf("module %q: %w", step.Name, err)
 		}
+		in.Input = v
+		if pn, ok := refNode(step.Input, nodes); ok {
+			in.Entities = filterEntities(pn.output.Entities, consumes)
+		}
+		return in, nil
+	}
 
-		result, err := runModule(ctx, filepath.Join(binDir, step.Name), sdk.Input{
-			Input:  input,
-			Config: step.Config,
-		})
+	if len(step.Inputs) == 0 {
+		return in, nil
+	}
+
+	fanIn := make(map[string]string, len(step.Inputs))
+	for _, ref := range step.Inputs {
+		name := strings.TrimPrefix(strings.TrimSpace(ref), "$")
+		name, _, _ = strings.Cut(name, ".")
+		v, err := resolveRef(ref, nodes)
 		if err != nil {
-			return fmt.Errorf("[%s] %w", step.Name, err)
+			return in, fmt.Errorf("module %q: %w", step.Name, err)
+		}
+		fanIn[name] = v
+		if pn, ok := nodes[name]; ok {
+			in.Entities = append(in.Entities, filterEntities(pn.output.Entities, consumes)...)
 		}
+	}
+	payload, err := json.Marshal(fanIn)
+	if err != nil {
+		return in, fmt.Errorf("module %q: marshal fan-in inputs: %w", step.Name, err)
+	}
+	in.Input = string(payload)
+	return in, nil
+}
 
-		outputs[step.Name] = result
+// runForEach runs step's module once per entity its foreach parent emits
+// (filtered to the types step declared it Consumes), merging every
+// invocation's entities and collecting their results into a JSON array.
+// Invocations run concurrently, at most maxParallel at a time under a limit
+// local to this call (separate from the one Run applies across steps),
+// instead of one entity at a time.
+func runForEach(ctx context.Context, binDir string, step Step, nodes map[string]*node) (Output, error) {
+	pn, ok := refNode(step.ForEach, nodes)
+	if !ok {
+		return Output{}, fmt.Errorf("module %q: foreach %q references a step that doesn't exist", step.Name, step.ForEach)
 	}
 
+	entities := filterEntities(pn.output.Entities, consumesFor(step.Name))
+	results := make([]string, len(entities))
+	entitiesByIdx := make([][]sdk.Entity, len(entities))
+
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for i, e := range entities {
+		wg.Add(1)
+		go func(i int, e sdk.Entity) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			out, err := runModule(ctx, filepath.Join(binDir, step.Name), sdk.Input{
+				Config:   step.Config,
+				Entities: []sdk.Entity{e},
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("foreach %s: %w", e.ID, err)
+				}
+				mu.Unlock()
+				return
+			}
+			results[i] = out.Result
+			entitiesByIdx[i] = out.Entities
+		}(i, e)
+	}
+	wg.Wait()
+
+	var merged Output
+	if firstErr != nil {
+		return merged, firstErr
+	}
+	for _, es := range entitiesByIdx {
+		merged.Entities = append(merged.Entities, es...)
+	}
+
+	payload, err := json.Marshal(results)
+	if err != nil {
+		return merged, fmt.Errorf("marshal foreach results: %w", err)
+	}
+	merged.Result = string(payload)
+	merged.JSON = jsonObject(merged.Result)
+	return merged, nil
+}
+
+// refNode resolves a $name / $name.field reference to the node it points
+// at, ignoring any .field suffix.
+func refNode(ref string, nodes map[string]*node) (*node, bool) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(ref), "$")
+	if !ok {
+		return nil, false
+	}
+	name, _, _ = strings.Cut(name, ".")
+	pn, ok := nodes[name]
+	return pn, ok
+}
+
+// consumesFor looks up the entity types an installed module declared it
+// accepts, so upstream entities can be filtered before being passed along.
+func consumesFor(name string) []string {
+	entry, ok := installer.LoadRegistry()[name]
+	if !ok {
+		return nil
+	}
+	return entry.Consumes
+}
+
+// filterEntities keeps only entities whose Type is in types. An empty types
+// (the module didn't declare Consumes) passes everything through, so
+// existing modules keep working unchanged.
+func filterEntities(entities []sdk.Entity, types []string) []sdk.Entity {
+	if len(types) == 0 {
+		return entities
+	}
+	var out []sdk.Entity
+	for _, e := range entities {
+		for _, t := range types {
+			if e.Type == t {
+				out = append(out, e)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// ValidateWiring checks that every step's module Consumes at least one
+// entity type its parents actually Emit (when both declare entity types at
+// all), catching mismatched wiring — e.g. shodan_lookup wired to a module
+// that never emits ip entities — before any subprocess runs.
+func ValidateWiring(p *Pipeline) error {
+	reg := installer.LoadRegistry()
+	for _, step := range p.Modules {
+		entry, ok := reg[step.Name]
+		if !ok || len(entry.Consumes) == 0 {
+			continue
+		}
+		for _, parent := range refs(step) {
+			pEntry, ok := reg[parent]
+			if !ok || len(pEntry.Emits) == 0 {
+				continue
+			}
+			if !sharesType(entry.Consumes, pEntry.Emits) {
+				return fmt.Errorf("%s consumes %v but %s only emits %v", step.Name, entry.Consumes, parent, pEntry.Emits)
+			}
+		}
+	}
 	return nil
 }
 
-func runModule(ctx context.Context, binPath string, in sdk.Input) (string, error) {
+// ValidateAcyclic checks that a pipeline's step references ($input/$inputs/
+// $foreach/$when) form a DAG. Without this, a reference cycle (a typo as
+// simple as two steps pointing at each other) would recurse forever in
+// assignContexts — a stack overflow that crashes the whole process, not just
+// the offending step — and even past that, Run's goroutines would deadlock
+// forever each waiting on the other's done channel. This runs before either,
+// so a malformed pipeline fails with a clean error instead.
+func ValidateAcyclic(p *Pipeline) error {
+	parents := make(map[string][]string, len(p.Modules))
+	for _, step := range p.Modules {
+		parents[step.Name] = refs(step)
+	}
+
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := make(map[string]int, len(parents))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case inProgress:
+			return fmt.Errorf("cycle in pipeline: %q depends (directly or transitively) on itself", name)
+		}
+		state[name] = inProgress
+		for _, parent := range parents[name] {
+			if _, ok := parents[parent]; !ok {
+				continue // unknown refs are reported when the step actually runs
+			}
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+
+	for name := range parents {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sharesType(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveRef resolves a literal or a $name / $name.field reference against
+// prior steps' outputs. .exit_code and .duration read straight off Output;
+// any other field falls back to the module's re-parsed Result JSON.
+func resolveRef(ref string, nodes map[string]*node) (string, error) {
+	ref = strings.TrimSpace(ref)
+	name, ok := strings.CutPrefix(ref, "$")
+	if !ok {
+		return strings.Trim(ref, `"`), nil
+	}
+
+	name, field, hasField := strings.Cut(name, ".")
+	pn, ok := nodes[name]
+	if !ok {
+		return "", fmt.Errorf("references output of %q but it hasn't run yet", name)
+	}
+	if !hasField {
+		return pn.output.Result, nil
+	}
+	if v, ok := outputField(pn.output, field); ok {
+		return v, nil
+	}
+	if pn.output.JSON == nil {
+		return "", fmt.Errorf("%q did not produce structured JSON output, cannot resolve .%s", name, field)
+	}
+	v, ok := pn.output.JSON[field]
+	if !ok {
+		return "", fmt.Errorf("%q has no field %q", name, field)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// outputField resolves a $step.field reference against Output's own fields
+// rather than its re-parsed Result JSON — the only two fields $step.field
+// promises that the module's own output never carries.
+func outputField(out Output, field string) (string, bool) {
+	switch field {
+	case "exit_code":
+		return fmt.Sprint(out.ExitCode), true
+	case "duration":
+		return out.Duration.String(), true
+	default:
+		return "", false
+	}
+}
+
+// evalWhen supports the small subset of expressions pipelines actually
+// need: `$ref == "literal"` and `$ref != "literal"`.
+func evalWhen(expr string, nodes map[string]*node) (bool, error) {
+	for _, op := range []string{"!=", "=="} {
+		lhs, rhs, ok := strings.Cut(expr, op)
+		if !ok {
+			continue
+		}
+		lv, err := resolveRef(lhs, nodes)
+		if err != nil {
+			return false, err
+		}
+		rv, err := resolveRef(rhs, nodes)
+		if err != nil {
+			return false, err
+		}
+		if op == "!=" {
+			return lv != rv, nil
+		}
+		return lv == rv, nil
+	}
+	return false, fmt.Errorf("unsupported expression: %q", expr)
+}
+
+func runModule(ctx context.Context, binPath string, in sdk.Input) (Output, error) {
 	payload, err := json.Marshal(in)
 	if err != nil {
-		return "", err
+		return Output{}, err
 	}
 
 	cmd := exec.CommandContext(ctx, binPath)
 	cmd.Stdin = bytes.NewReader(payload)
-	cmd.Stdout = os.Stdout // module prints directly to terminal
 	cmd.Stderr = os.Stderr
 
-	// needs both passthrough and capture of stdout for piping.
-	// using a custom writer that tees to stdout and a buffer.
-	var buf bytes.Buffer
-	tee := &teeWriter{w: os.Stdout, buf: &buf}
-	cmd.Stdout = tee
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Output{}, fmt.Errorf("stdout pipe: %w", err)
+	}
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("exited with error: %w", err)
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return Output{}, fmt.Errorf("start: %w", err)
 	}
 
-	// Extract result field from the module's JSON output
-	var out sdk.Output
-	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
-		// Module may print non-JSON lines before the final JSON — find last line
-		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
-		for i := len(lines) - 1; i >= 0; i-- {
-			if err2 := json.Unmarshal([]byte(lines[i]), &out); err2 == nil {
-				return out.Result, nil
-			}
+	out, parseErr := streamOutput(stdout)
+	runErr := cmd.Wait()
+	duration := time.Since(start)
+
+	exitCode := 0
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else {
+			return Output{Duration: duration}, fmt.Errorf("exited with error: %w", runErr)
 		}
-		return "", fmt.Errorf("could not parse output JSON: %w", err)
 	}
 
-	return out.Result, nil
+	result := Output{
+		Result:   out.Result,
+		JSON:     jsonObject(out.Result),
+		Entities: out.Entities,
+		Duration: duration,
+		ExitCode: exitCode,
+	}
+	if runErr != nil {
+		return result, fmt.Errorf("exited with error: %w", runErr)
+	}
+	if parseErr != nil {
+		return result, parseErr
+	}
+	return result, nil
 }
 
-// teeWriter writes to both a passthrough writer and an internal buffer.
-type teeWriter struct {
-	w   *os.File
-	buf *bytes.Buffer
+// streamOutput reads a module's stdout as newline-delimited JSON as it
+// arrives rather than buffering the whole run before parsing anything: a
+// module using sdk.Run's emit callback writes one {"entities":[...]} object
+// per emitted entity as soon as it finds one, followed by a final object
+// carrying Result, and each line is parsed off the pipe as soon as it's
+// flushed. Every line that parses contributes its Entities; the last line
+// with a non-empty Result wins. Every byte read is also mirrored to
+// os.Stdout, so the module's output is still visible on the terminal as it
+// runs rather than only being written out after it exits.
+func streamOutput(stdout io.Reader) (sdk.Output, error) {
+	var merged sdk.Output
+	found := false
+	scanner := bufio.NewScanner(io.TeeReader(stdout, os.Stdout))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var out sdk.Output
+		if err := json.Unmarshal([]byte(line), &out); err != nil {
+			continue
+		}
+		found = true
+		merged.Entities = append(merged.Entities, out.Entities...)
+		if out.Result != "" {
+			merged.Result = out.Result
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return merged, fmt.Errorf("reading module stdout: %w", err)
+	}
+	if !found {
+		return merged, fmt.Errorf("could not parse output JSON")
+	}
+	return merged, nil
 }
 
-func (t *teeWriter) Write(p []byte) (int, error) {
-	t.buf.Write(p)
-	return t.w.Write(p)
-}
\ No newline at end of file
+// jsonObject re-parses a result string as a JSON object so downstream
+// steps can reference $step.field; non-object results simply have no
+// addressable fields.
+func jsonObject(result string) map[string]any {
+	var m map[string]any
+	if err := json.Unmarshal([]byte(result), &m); err != nil {
+		return nil
+	}
+	return m
+}
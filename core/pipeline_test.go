@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValidateAcyclicDetectsCycle(t *testing.T) {
+	p := &Pipeline{Modules: []Step{
+		{Name: "a", Input: "$b"},
+		{Name: "b", Input: "$a"},
+	}}
+	if err := ValidateAcyclic(p); err == nil {
+		t.Fatal("expected a cycle error for a <-> b")
+	}
+}
+
+func TestValidateAcyclicAllowsDAG(t *testing.T) {
+	p := &Pipeline{Modules: []Step{
+		{Name: "a"},
+		{Name: "b", Input: "$a"},
+		{Name: "c", Inputs: []string{"$a", "$b"}},
+	}}
+	if err := ValidateAcyclic(p); err != nil {
+		t.Fatalf("unexpected error for a valid DAG: %v", err)
+	}
+}
+
+func TestResolveRefOutputFields(t *testing.T) {
+	nodes := map[string]*node{
+		"a": {output: Output{ExitCode: 7, Duration: 2 * time.Second}},
+	}
+	if got, err := resolveRef("$a.exit_code", nodes); err != nil || got != "7" {
+		t.Fatalf("resolveRef($a.exit_code) = %q, %v", got, err)
+	}
+	if got, err := resolveRef("$a.duration", nodes); err != nil || got != "2s" {
+		t.Fatalf("resolveRef($a.duration) = %q, %v", got, err)
+	}
+}
+
+func TestAssignContextsPropagatesCancelToDependents(t *testing.T) {
+	nodes := map[string]*node{
+		"a": {parents: nil},
+		"b": {parents: []string{"a"}},
+	}
+	assignContexts(context.Background(), nodes)
+	defer func() {
+		for _, n := range nodes {
+			n.cancel()
+		}
+	}()
+
+	nodes["a"].cancel()
+	select {
+	case <-nodes["b"].ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("b's context should be canceled when its parent a's is")
+	}
+}
+
+func TestAssignContextsIsolatesIndependentBranches(t *testing.T) {
+	nodes := map[string]*node{
+		"a": {parents: nil},
+		"b": {parents: nil},
+	}
+	assignContexts(context.Background(), nodes)
+	defer func() {
+		for _, n := range nodes {
+			n.cancel()
+		}
+	}()
+
+	nodes["a"].cancel()
+	select {
+	case <-nodes["b"].ctx.Done():
+		t.Fatal("canceling an independent node must not cancel an unrelated sibling")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/its-ernest/opentrace/installer"
+	"gopkg.in/yaml.v3"
+)
+
+// maxTemplateDepth guards against a template chain that loops back on itself.
+const maxTemplateDepth = 20
+
+// topLevelKeyRe matches an unindented `key: value` line, used by peekMeta to
+// scan a pipeline file's top-level keys without fully parsing it as YAML.
+var topLevelKeyRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_-]*):\s*(.*)$`)
+
+func templatesDir() string {
+	h, _ := os.UserHomeDir()
+	return filepath.Join(h, ".opentrace", "templates")
+}
+
+func Load(path string) (*Pipeline, error) {
+	p, err := resolve(path, filepath.Dir(path), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Modules) == 0 {
+		return nil, fmt.Errorf("pipeline has no modules")
+	}
+	if err := ValidateAcyclic(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// resolve reads ref (a path, a bare template name, or a git ref), merges its
+// `data:` under childData (childData wins — the file doing the importing
+// always overrides the defaults of what it imports), and either recurses
+// into the template it `load:`s or renders it as the terminal pipeline.
+func resolve(ref, baseDir string, childData map[string]any, depth int) (*Pipeline, error) {
+	if depth > maxTemplateDepth {
+		return nil, fmt.Errorf("template chain too deep (possible cycle) resolving %q", ref)
+	}
+
+	raw, nextBaseDir, err := readTemplateSource(ref, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, load, fileData, err := peekMeta(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pipeline YAML in %q: %w", ref, err)
+	}
+	data := mergeData(fileData, childData)
+
+	if kind == "template" {
+		if load == "" {
+			return nil, fmt.Errorf("%q has kind: template but no load:", ref)
+		}
+		return resolve(load, nextBaseDir, data, depth+1)
+	}
+
+	rendered, err := renderTemplate(raw, data)
+	if err != nil {
+		return nil, fmt.Errorf("rendering %q: %w", ref, err)
+	}
+	var p Pipeline
+	if err := yaml.Unmarshal(rendered, &p); err != nil {
+		return nil, fmt.Errorf("invalid pipeline YAML in %q after rendering: %w", ref, err)
+	}
+	return &p, nil
+}
+
+// peekMeta reads kind, load, and data off ref's top-level keys with a
+// line-oriented scan instead of a full yaml.Unmarshal. A full parse has to
+// run before rendering — but modules: entries may wrap a list item in
+// `{{ if .flag }}`/`{{ end }}`, which isn't valid YAML syntax until the
+// template is rendered, so parsing the whole document here would reject the
+// exact use case templates exist for. kind/load/data are always plain YAML
+// regardless, so they can be read first and data fed into the render.
+func peekMeta(raw []byte) (kind, load string, data map[string]any, err error) {
+	var dataBlock []string
+	inData := false
+	for _, line := range strings.Split(string(raw), "\n") {
+		if inData {
+			if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+				dataBlock = append(dataBlock, line)
+				continue
+			}
+			inData = false
+		}
+		m := topLevelKeyRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key, rest := m[1], strings.TrimSpace(m[2])
+		switch key {
+		case "kind":
+			kind = trimYAMLScalar(rest)
+		case "load":
+			load = trimYAMLScalar(rest)
+		case "data":
+			inData = true
+			dataBlock = nil
+			if rest != "" {
+				dataBlock = []string{rest} // flow form, e.g. `data: {foo: bar}`
+			}
+		}
+	}
+	if len(dataBlock) == 0 {
+		return kind, load, nil, nil
+	}
+	if err := yaml.Unmarshal([]byte(strings.Join(dataBlock, "\n")), &data); err != nil {
+		return "", "", nil, fmt.Errorf("invalid data: block: %w", err)
+	}
+	return kind, load, data, nil
+}
+
+// trimYAMLScalar strips a trailing comment and surrounding quotes from a
+// single-line scalar value, e.g. `template # a preset` or `"template"`.
+func trimYAMLScalar(s string) string {
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.Trim(strings.TrimSpace(s), `"'`)
+}
+
+// mergeData layers child over parent, child's keys winning on conflict.
+func mergeData(parent, child map[string]any) map[string]any {
+	merged := make(map[string]any, len(parent)+len(child))
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range child {
+		merged[k] = v
+	}
+	return merged
+}
+
+// renderTemplate executes raw as a text/template, with data addressable as
+// `.` (so `{{ .target }}` and `{{ if .use_shodan }}` work) and `env` exposed
+// for reading environment variables, replacing the old os.ExpandEnv pass.
+func renderTemplate(raw []byte, data map[string]any) ([]byte, error) {
+	tmpl, err := template.New("pipeline").Funcs(template.FuncMap{"env": os.Getenv}).Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("executing template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readTemplateSource resolves ref to file contents and the directory
+// subsequent relative `load:`s from it should be resolved against. ref may
+// be:
+//   - a path relative to baseDir, or absolute
+//   - a bare name, looked up in ~/.opentrace/templates/<name>.yaml
+//   - a git ref of the form <repo>#<path-in-repo>, sparse-cloned the same
+//     way installFromRegistry fetches opentrace-modules/registry.json
+func readTemplateSource(ref, baseDir string) (data []byte, nextBaseDir string, err error) {
+	if repo, file, ok := strings.Cut(ref, "#"); ok && looksLikeGitRepo(repo) {
+		data, err := installer.FetchFile(repo, file)
+		if err != nil {
+			return nil, "", fmt.Errorf("fetch template %q from %s: %w", file, repo, err)
+		}
+		return data, templatesDir(), nil
+	}
+
+	refPath := filepath.Join(baseDir, ref)
+	if filepath.IsAbs(ref) {
+		refPath = ref
+	}
+	candidates := []string{
+		refPath,
+		filepath.Join(templatesDir(), ref),
+		filepath.Join(templatesDir(), ref+".yaml"),
+	}
+	for _, c := range candidates {
+		if b, err := os.ReadFile(c); err == nil {
+			return b, filepath.Dir(c), nil
+		}
+	}
+	return nil, "", fmt.Errorf("template %q not found (looked relative to %s and in %s)", ref, baseDir, templatesDir())
+}
+
+func looksLikeGitRepo(s string) bool {
+	return strings.HasPrefix(s, "github.com/") || strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
@@ -1,39 +1,86 @@
 package installer
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
 
+	"golang.org/x/mod/semver"
 	"gopkg.in/yaml.v3"
 )
 
 const registryRepo = "https://github.com/its-ernest/opentrace-modules"
 
 type Manifest struct {
-	Name        string   `yaml:"name"`
-	Version     string   `yaml:"version"`
-	Description string   `yaml:"description"`
-	Author      string   `yaml:"author"`
-	EntityTypes []string `yaml:"entity_types"`
+	Name        string            `yaml:"name"`
+	Version     string            `yaml:"version"`
+	Description string            `yaml:"description"`
+	Author      string            `yaml:"author"`
+	Consumes    []string          `yaml:"consumes"` // entity types this module accepts as input, e.g. [ip]
+	Emits       []string          `yaml:"emits"`    // entity types this module's Output.Entities can contain, e.g. [ip]
+	Requires    map[string]string `yaml:"requires"` // other modules this one depends on, e.g. {ip_locator: "^1.2"}
+
+	// Runtime selects how the module is built/provisioned: "go" (default),
+	// "python", "node", "binary", or "docker". Whatever it is, the result
+	// must still speak the stdin/stdout JSON contract in sdk.Input/Output.
+	Runtime    string         `yaml:"runtime"`
+	Entrypoint string         `yaml:"entrypoint"` // python: module passed to `python -m`; node: script passed to `node`
+	Image      string         `yaml:"image"`      // docker: image reference the shim runs
+	Releases   []ReleaseAsset `yaml:"releases"` // binary: per-OS/arch download
+}
+
+// ReleaseAsset is one per-OS/arch download for a `runtime: binary` module.
+type ReleaseAsset struct {
+	OS     string `yaml:"os"`
+	Arch   string `yaml:"arch"`
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// ManifestLock pins the exact state a module was built and signed from, so
+// a reinstall can be verified to reproduce the same binary rather than
+// trusting whatever the repo currently serves.
+type ManifestLock struct {
+	Commit     string `yaml:"commit"`
+	GoVersion  string `yaml:"go_version"`
+	GoSumHash  string `yaml:"go_sum_hash"`
+	BinaryHash string `yaml:"binary_hash"`
 }
 
 type RegistryEntry struct {
-	BinPath string `json:"bin_path"`
-	Version string `json:"version"`
-	Author  string `json:"author"`
-	Repo    string `json:"repo"`
+	BinPath    string `json:"bin_path"`
+	Version    string `json:"version"`
+	Author     string `json:"author"`
+	Repo       string `json:"repo"`
+	Commit     string `json:"commit"`
+	BinaryHash string `json:"binary_hash"`
+	Verified   bool   `json:"verified"` // manifest.lock matched AND was signed by a trusted key — an unsigned hash match is recorded, not verified
+
+	Constraint    string `json:"constraint,omitempty"`     // semver constraint update/outdated resolve against, e.g. "^1.2"
+	LatestVersion string `json:"latest_version,omitempty"` // highest tag satisfying Constraint, as of the last update (outdated does not record it)
+
+	Consumes []string `json:"consumes,omitempty"` // entity types accepted, copied from manifest.yaml at install time
+	Emits    []string `json:"emits,omitempty"`    // entity types produced, copied from manifest.yaml at install time
 }
 
 type Registry map[string]RegistryEntry
 
-func home() string         { h, _ := os.UserHomeDir(); return h }
-func BinDir() string       { return filepath.Join(home(), ".opentrace", "bin") }
-func registryPath() string { return filepath.Join(home(), ".opentrace", "registry.json") }
+func home() string                  { h, _ := os.UserHomeDir(); return h }
+func BinDir() string                { return filepath.Join(home(), ".opentrace", "bin") }
+func registryPath() string          { return filepath.Join(home(), ".opentrace", "registry.json") }
+func trustedKeysDir() string        { return filepath.Join(home(), ".opentrace", "trusted_keys") }
+func lockPath(srcDir string) string { return filepath.Join(srcDir, "manifest.lock") }
+func sigPath(srcDir string) string  { return filepath.Join(srcDir, "manifest.lock.sig") }
 
 func LoadRegistry() Registry {
 	r := Registry{}
@@ -51,20 +98,68 @@ func saveRegistry(r Registry) error {
 	return os.WriteFile(registryPath(), data, 0o644)
 }
 
+// FetchFile sparse-clones repoURL at HEAD and returns the contents of a
+// single file from it, without paying for a full clone. This is the trick
+// installFromRegistry uses to read opentrace-modules/registry.json, and is
+// exported so other packages (e.g. core's pipeline template resolver) can
+// pull a single file out of a git repo the same way.
+func FetchFile(repoURL, file string) ([]byte, error) {
+	tmp, err := os.MkdirTemp("", "opentrace-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if out, err := exec.Command("git", "clone",
+		"--depth=1", "--filter=blob:none", "--sparse",
+		repoURL, tmp,
+	).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("git clone: %s: %w", string(out), err)
+	}
+	if out, err := exec.Command("git", "-C", tmp,
+		"sparse-checkout", "set", file,
+	).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("sparse-checkout: %s: %w", string(out), err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmp, file))
+	if err != nil {
+		return nil, fmt.Errorf("read %s from %s: %w", file, repoURL, err)
+	}
+	return data, nil
+}
+
 // Install is the single entry point.
 //
-// Two forms accepted:
+// Three forms accepted:
 //   opentrace install ip_locator                              → looks up name in opentrace-modules registry
 //   opentrace install github.com/user/repo                   → clones directly from that repo
-func Install(arg string) error {
+//   opentrace install ip_locator@^1.2                         → either form, pinning update/outdated to a constraint
+//
+// unsafe skips manifest.lock verification (reproducible-build hash and
+// signature checks) — see build. It exists for modules that haven't
+// adopted manifest.lock yet; leave it false whenever possible.
+func Install(arg string, unsafe bool) error {
 	if err := os.MkdirAll(BinDir(), 0o755); err != nil {
 		return fmt.Errorf("mkdir: %w", err)
 	}
 
-	if isRepoPath(arg) {
-		return installFromRepo(arg)
+	target, constraint := splitConstraint(arg)
+	if isRepoPath(target) {
+		return installFromRepo(target, constraint, unsafe)
+	}
+	return installFromRegistry(target, constraint, unsafe)
+}
+
+// splitConstraint pulls a trailing "@<constraint>" off arg, e.g.
+// "ip_locator@^1.2" → ("ip_locator", "^1.2"). A bare name or repo path with
+// no "@" is returned with an empty constraint.
+func splitConstraint(arg string) (target, constraint string) {
+	target, constraint, ok := strings.Cut(arg, "@")
+	if !ok {
+		return arg, ""
 	}
-	return installFromRegistry(arg)
+	return target, constraint
 }
 
 // isRepoPath returns true if arg looks like a repo path (contains a slash).
@@ -74,31 +169,10 @@ func isRepoPath(arg string) bool {
 
 // installFromRegistry looks up the module name in opentrace-modules/registry.json
 // then delegates to installFromRepo using the registered repo URL.
-func installFromRegistry(name string) error {
-	tmp, err := os.MkdirTemp("", "opentrace-*")
-	if err != nil {
-		return err
-	}
-	defer os.RemoveAll(tmp)
-
+func installFromRegistry(name, constraint string, unsafe bool) error {
 	fmt.Printf("  looking up %s in registry...\n", name)
 
-	// sparse clone just the registry.json
-	if out, err := exec.Command("git", "clone",
-		"--depth=1", "--filter=blob:none", "--sparse",
-		registryRepo, tmp,
-	).CombinedOutput(); err != nil {
-		return fmt.Errorf("git clone: %s: %w", string(out), err)
-	}
-
-	if out, err := exec.Command("git", "-C", tmp,
-		"sparse-checkout", "set", "registry.json",
-	).CombinedOutput(); err != nil {
-		return fmt.Errorf("sparse-checkout: %s: %w", string(out), err)
-	}
-
-	// read registry.json
-	regData, err := os.ReadFile(filepath.Join(tmp, "registry.json"))
+	regData, err := FetchFile(registryRepo, "registry.json")
 	if err != nil {
 		return fmt.Errorf("cannot read registry.json from opentrace-modules: %w", err)
 	}
@@ -120,16 +194,55 @@ func installFromRegistry(name string) error {
 	}
 
 	fmt.Printf("  found %s → %s\n", name, repoURL)
-	return installFromRepo(repoURL)
+	return installFromRepo(repoURL, constraint, unsafe)
 }
 
 // installFromRepo clones a repo directly and builds the module.
 // arg can be:
 //   github.com/user/repo
 //   https://github.com/user/repo
-func installFromRepo(arg string) error {
+func installFromRepo(arg, constraint string, unsafe bool) error {
+	repoURL, localName, manifest, tmp, err := fetchModule(arg)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	printManifest(manifest, repoURL)
+
+	// always prompt — no module is pre-trusted
+	fmt.Printf("  install %s? (y/n): ", localName)
+	var confirm string
+	fmt.Scan(&confirm)
+	if strings.ToLower(confirm) != "y" {
+		fmt.Println("  aborted.")
+		return nil
+	}
+
+	entry, err := build(localName, tmp, manifest, repoURL, constraint, unsafe)
+	if err != nil {
+		return err
+	}
+	reg := LoadRegistry()
+	reg[localName] = entry
+	return saveRegistry(reg)
+}
+
+// fetchModule clones arg into a temp dir and reads its manifest.yaml,
+// without building or prompting — the shared first half of installFromRepo
+// and Update, which differ only in whether a human confirms the result.
+// The caller owns cleaning up tmp.
+func fetchModule(arg string) (repoURL, localName string, manifest *Manifest, tmp string, err error) {
+	return fetchModuleRef(arg, "")
+}
+
+// fetchModuleRef is fetchModule, but checks out ref (a tag or branch name)
+// instead of the repo's default branch when ref is non-empty — the only way
+// Update actually builds the version planUpdates resolved to, rather than
+// whatever HEAD happens to be.
+func fetchModuleRef(arg, ref string) (repoURL, localName string, manifest *Manifest, tmp string, err error) {
 	// normalize to full URL
-	repoURL := arg
+	repoURL = arg
 	if !strings.HasPrefix(arg, "https://") && !strings.HasPrefix(arg, "http://") {
 		repoURL = "https://" + arg
 	}
@@ -138,78 +251,643 @@ func installFromRepo(arg string) error {
 	// github.com/user/opentrace-face-osint → face-osint
 	// github.com/user/contacts_graph_extract → contacts_graph_extract
 	lastSegment := arg[strings.LastIndex(arg, "/")+1:]
-	localName := strings.TrimPrefix(lastSegment, "opentrace-")
+	localName = strings.TrimPrefix(lastSegment, "opentrace-")
 
-	tmp, err := os.MkdirTemp("", "opentrace-*")
+	tmp, err = os.MkdirTemp("", "opentrace-*")
 	if err != nil {
-		return err
+		return
 	}
-	defer os.RemoveAll(tmp)
 
-	fmt.Printf("  cloning %s...\n", repoURL)
+	cloneArgs := []string{"clone", "--depth=1"}
+	if ref != "" {
+		cloneArgs = append(cloneArgs, "--branch", ref)
+	}
+	cloneArgs = append(cloneArgs, repoURL, tmp)
 
-	if out, err := exec.Command("git", "clone",
-		"--depth=1", repoURL, tmp,
-	).CombinedOutput(); err != nil {
-		return fmt.Errorf("git clone failed: %s: %w", string(out), err)
+	if ref != "" {
+		fmt.Printf("  cloning %s @ %s...\n", repoURL, ref)
+	} else {
+		fmt.Printf("  cloning %s...\n", repoURL)
+	}
+
+	if out, cloneErr := exec.Command("git", cloneArgs...).CombinedOutput(); cloneErr != nil {
+		os.RemoveAll(tmp)
+		err = fmt.Errorf("git clone failed: %s: %w", string(out), cloneErr)
+		return
 	}
 
-	// read manifest from root of repo
-	manifest, err := readManifest(filepath.Join(tmp, "manifest.yaml"))
+	manifest, err = readManifest(filepath.Join(tmp, "manifest.yaml"))
 	if err != nil {
-		return fmt.Errorf("manifest: %w", err)
+		os.RemoveAll(tmp)
+		err = fmt.Errorf("manifest: %w", err)
+		return
 	}
 
 	// manifest name takes priority over derived name
 	if manifest.Name != "" {
 		localName = manifest.Name
 	}
+	return
+}
 
-	printManifest(manifest, repoURL)
+// builders map a manifest's runtime to the function that provisions it.
+// Every builder returns the path BinDir() should point runModule at and
+// whether that result could be verified (against manifest.lock for go,
+// against a release's sha256 for binary — the scripted runtimes have
+// nothing stable enough to pin, so they always report unverified).
+var builders = map[string]func(name, srcDir string, manifest *Manifest, unsafe bool) (binPath string, verified bool, err error){
+	"":       buildGo, // manifests written before `runtime:` existed
+	"go":     buildGo,
+	"python": buildPython,
+	"node":   buildNode,
+	"binary": buildBinaryRelease,
+	"docker": buildDocker,
+}
 
-	// always prompt — no module is pre-trusted
-	fmt.Printf("  install %s? (y/n): ", localName)
-	var confirm string
-	fmt.Scan(&confirm)
-	if strings.ToLower(confirm) != "y" {
-		fmt.Println("  aborted.")
-		return nil
+// build provisions the module via the builder matching its runtime and
+// returns the registry entry to record for it. It does not touch the
+// registry itself — callers own loading and saving it, so a caller updating
+// several modules (e.g. Update, which reloads and saves around each build)
+// decides when the registry is read and written.
+//
+// constraint is recorded on the returned entry as-is; pass the module's
+// existing entry.Constraint to preserve it across a rebuild, or "" if none
+// was pinned.
+func build(name, srcDir string, manifest *Manifest, repo, constraint string, unsafe bool) (RegistryEntry, error) {
+	if err := CheckRequires(manifest.Requires); err != nil {
+		return RegistryEntry{}, fmt.Errorf("%s: %w", name, err)
 	}
 
-	return build(localName, tmp, manifest, repoURL)
+	fn, ok := builders[manifest.Runtime]
+	if !ok {
+		return RegistryEntry{}, fmt.Errorf("unknown runtime %q (want go, python, node, binary, or docker)", manifest.Runtime)
+	}
+	if err := requireLockForRuntime(srcDir, manifest.Runtime, unsafe); err != nil {
+		return RegistryEntry{}, err
+	}
+
+	fmt.Printf("  building %s@%s (%s)...\n", name, manifest.Version, runtimeLabel(manifest.Runtime))
+
+	binPath, verified, err := fn(name, srcDir, manifest, unsafe)
+	if err != nil {
+		return RegistryEntry{}, err
+	}
+
+	binaryHash, _ := hashFile(binPath)
+	commit, _ := commitSHA(srcDir)
+
+	fmt.Printf("  ✓ %s@%s installed → %s\n", name, manifest.Version, binPath)
+	return RegistryEntry{
+		BinPath:    binPath,
+		Version:    manifest.Version,
+		Author:     manifest.Author,
+		Repo:       repo,
+		Commit:     commit,
+		BinaryHash: binaryHash,
+		Verified:   verified,
+		Constraint: constraint,
+		Consumes:   manifest.Consumes,
+		Emits:      manifest.Emits,
+	}, nil
 }
 
-// build compiles the module and registers it locally.
-func build(name, srcDir string, manifest *Manifest, repo string) error {
+func runtimeLabel(r string) string {
+	if r == "" {
+		return "go"
+	}
+	return r
+}
+
+// buildGo is the original build path: compile with the toolchain and check
+// the result against manifest.lock (unless unsafe is set).
+func buildGo(name, srcDir string, manifest *Manifest, unsafe bool) (string, bool, error) {
 	binName := name
 	if runtime.GOOS == "windows" {
 		binName += ".exe"
 	}
 	binPath := filepath.Join(BinDir(), binName)
 
-	fmt.Printf("  building %s@%s...\n", name, manifest.Version)
-
 	if out, err := exec.Command(
 		"go", "build", "-trimpath", "-o", binPath, srcDir,
 	).CombinedOutput(); err != nil {
-		return fmt.Errorf("build failed:\n%s", string(out))
+		return "", false, fmt.Errorf("build failed:\n%s", string(out))
+	}
+
+	binaryHash, err := hashFile(binPath)
+	if err != nil {
+		return "", false, fmt.Errorf("hash built binary: %w", err)
+	}
+	commit, _ := commitSHA(srcDir)
+	goSum, _ := goSumHash(srcDir)
+
+	verified, err := verifyLock(srcDir, manifest.Author, commit, goSum, binaryHash)
+	if err != nil {
+		if !unsafe {
+			_ = os.Remove(binPath)
+			return "", false, fmt.Errorf("%w (pass --unsafe to install anyway)", err)
+		}
+		fmt.Printf("  ⚠ %v — installing anyway (--unsafe)\n", err)
+	} else if !verified {
+		fmt.Println("  ⚠ manifest.lock hash recorded, but unsigned — nothing outside this clone vouches for it")
+	}
+	return binPath, verified, nil
+}
+
+// buildPython provisions a venv under ~/.opentrace/envs/<name>/, installs
+// requirements.txt if present, and shims `python -m <entrypoint>`.
+func buildPython(name, srcDir string, manifest *Manifest, unsafe bool) (string, bool, error) {
+	if manifest.Entrypoint == "" {
+		return "", false, fmt.Errorf("python runtime requires entrypoint in manifest.yaml")
+	}
+
+	envDir := filepath.Join(home(), ".opentrace", "envs", name)
+	if out, err := exec.Command("python3", "-m", "venv", envDir).CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("python3 -m venv: %s: %w", string(out), err)
+	}
+	python := filepath.Join(envDir, "bin", "python")
+
+	if reqs := filepath.Join(srcDir, "requirements.txt"); fileExists(reqs) {
+		if out, err := exec.Command(python, "-m", "pip", "install", "-q", "-r", reqs).CombinedOutput(); err != nil {
+			return "", false, fmt.Errorf("pip install: %s: %w", string(out), err)
+		}
+	}
+
+	binPath, err := writeShim(name, python, "-m", manifest.Entrypoint)
+	return binPath, false, err
+}
+
+// buildNode runs `npm ci` against the module's package.json and shims
+// `node <entrypoint>` (default dist/index.js).
+func buildNode(name, srcDir string, manifest *Manifest, unsafe bool) (string, bool, error) {
+	entrypoint := manifest.Entrypoint
+	if entrypoint == "" {
+		entrypoint = "dist/index.js"
+	}
+
+	if out, err := exec.Command("npm", "ci", "--prefix", srcDir).CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("npm ci: %s: %w", string(out), err)
+	}
+	node, err := exec.LookPath("node")
+	if err != nil {
+		return "", false, fmt.Errorf("node runtime requires node on PATH: %w", err)
+	}
+
+	binPath, err := writeShim(name, node, filepath.Join(srcDir, entrypoint))
+	return binPath, false, err
+}
+
+// buildBinaryRelease downloads the release asset matching the local
+// OS/arch and verifies it against the declared SHA-256.
+func buildBinaryRelease(name, srcDir string, manifest *Manifest, unsafe bool) (string, bool, error) {
+	asset := findRelease(manifest.Releases, runtime.GOOS, runtime.GOARCH)
+	if asset == nil {
+		return "", false, fmt.Errorf("no release asset for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+
+	binPath := filepath.Join(BinDir(), name)
+	if err := downloadFile(asset.URL, binPath); err != nil {
+		return "", false, fmt.Errorf("download release: %w", err)
+	}
+
+	hash, err := hashFile(binPath)
+	if err != nil {
+		return "", false, err
+	}
+	verified := asset.SHA256 != "" && hash == asset.SHA256
+	if asset.SHA256 != "" && !verified && !unsafe {
+		_ = os.Remove(binPath)
+		return "", false, fmt.Errorf("release asset sha256 %s does not match manifest %s (pass --unsafe to install anyway)", hash, asset.SHA256)
+	}
+	if err := os.Chmod(binPath, 0o755); err != nil {
+		return "", false, err
+	}
+	return binPath, verified, nil
+}
+
+// buildDocker shims `docker run -i --rm <image>`; nothing is built locally.
+func buildDocker(name, srcDir string, manifest *Manifest, unsafe bool) (string, bool, error) {
+	if manifest.Image == "" {
+		return "", false, fmt.Errorf("docker runtime requires image in manifest.yaml")
+	}
+	binPath, err := writeShim(name, "docker", "run", "-i", "--rm", manifest.Image)
+	return binPath, false, err
+}
+
+// writeShim writes an executable script into BinDir() that execs cmd with
+// args, forwarding opentrace's own arguments, for runtimes that don't
+// produce a binary opentrace can run directly.
+func writeShim(name, cmd string, args ...string) (string, error) {
+	binPath := filepath.Join(BinDir(), name)
+	script := fmt.Sprintf("#!/bin/sh\nexec %s %s \"$@\"\n", cmd, strings.Join(args, " "))
+	if err := os.WriteFile(binPath, []byte(script), 0o755); err != nil {
+		return "", fmt.Errorf("write shim: %w", err)
+	}
+	return binPath, nil
+}
+
+func findRelease(releases []ReleaseAsset, goos, goarch string) *ReleaseAsset {
+	for i := range releases {
+		if releases[i].OS == goos && releases[i].Arch == goarch {
+			return &releases[i]
+		}
+	}
+	return nil
+}
+
+func downloadFile(url, dest string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, resp.Body)
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// requireLockForRuntime enforces the manifest.lock gate buildGo already
+// applies internally (via verifyLock) for every other runtime too. Python,
+// Node, and Docker modules can't pin a single binary hash the way a Go build
+// can, but without this a module skips manifest.lock review entirely just
+// by declaring a non-go runtime — so at minimum, require the file to exist.
+func requireLockForRuntime(srcDir, rt string, unsafe bool) error {
+	if rt == "" || rt == "go" {
+		return nil // buildGo runs its own, stricter check
+	}
+	if unsafe {
+		return nil
+	}
+	if !fileExists(lockPath(srcDir)) {
+		return fmt.Errorf("no manifest.lock in repo — build is unverified (pass --unsafe to install anyway)")
+	}
+	return nil
+}
+
+// verifyLock checks the built binary against srcDir/manifest.lock, and the
+// lock itself against manifest.lock.sig (if the author's public key is in
+// the trusted_keys allowlist). It returns (true, nil) only when a lock was
+// present, every check passed, AND the lock was signed by a key trusted
+// before this install — that's the only case anything outside the clone
+// itself vouches for the result. A repo that's compromised controls both the
+// binary and the manifest.lock checked against it, so an unsigned match just
+// means the two agree with each other, not that either is trustworthy; it's
+// reported as hash-recorded (false) rather than verified.
+func verifyLock(srcDir, author, commit, goSum, binaryHash string) (bool, error) {
+	data, err := os.ReadFile(lockPath(srcDir))
+	if os.IsNotExist(err) {
+		return false, fmt.Errorf("no manifest.lock in repo — build is unverified")
+	}
+	if err != nil {
+		return false, fmt.Errorf("read manifest.lock: %w", err)
+	}
+
+	var lock ManifestLock
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return false, fmt.Errorf("invalid manifest.lock: %w", err)
+	}
+
+	signed, err := verifyLockSignature(data, srcDir, author)
+	if err != nil {
+		return false, err
+	}
+
+	if lock.Commit != "" && commit != "" && lock.Commit != commit {
+		return false, fmt.Errorf("manifest.lock commit %s does not match checked-out commit %s", lock.Commit, commit)
+	}
+	if lock.GoVersion != "" && lock.GoVersion != goVersionString() {
+		return false, fmt.Errorf("manifest.lock go_version %q does not match local toolchain %q", lock.GoVersion, goVersionString())
+	}
+	if lock.GoSumHash != "" && goSum != "" && lock.GoSumHash != goSum {
+		return false, fmt.Errorf("manifest.lock go_sum_hash %s does not match module's go.sum %s", lock.GoSumHash, goSum)
+	}
+	if lock.BinaryHash != binaryHash {
+		return false, fmt.Errorf("build is not reproducible: manifest.lock binary_hash %s does not match built binary %s", lock.BinaryHash, binaryHash)
+	}
+
+	return signed, nil
+}
+
+// goSumHash hashes the module's go.sum so manifest.lock can pin the exact
+// dependency set a reproducible build was verified against.
+func goSumHash(srcDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(srcDir, "go.sum"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func goVersionString() string {
+	out, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return runtime.Version()
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// verifyLockSignature checks manifest.lock.sig against the author's public
+// key under ~/.opentrace/trusted_keys/, reporting whether the lock is
+// actually signature-backed. A missing signature or missing key is not an
+// error by itself — manifest.lock is still checked for a reproducible
+// build, it's just unattested, so signed comes back false — but a signature
+// that fails to verify against a known key is an error.
+func verifyLockSignature(lockData []byte, srcDir, author string) (signed bool, err error) {
+	sigData, err := os.ReadFile(sigPath(srcDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read manifest.lock.sig: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil {
+		return false, fmt.Errorf("invalid manifest.lock.sig encoding: %w", err)
+	}
+
+	keyData, err := os.ReadFile(filepath.Join(trustedKeysDir(), author+".pub"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read trusted key for %q: %w", author, err)
+	}
+	pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("invalid trusted public key for %q", author)
 	}
 
+	if !ed25519.Verify(ed25519.PublicKey(pub), lockData, sig) {
+		return false, fmt.Errorf("manifest.lock.sig does not match trusted key for %q", author)
+	}
+	return true, nil
+}
+
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func commitSHA(srcDir string) (string, error) {
+	out, err := exec.Command("git", "-C", srcDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse HEAD: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Verify re-hashes an installed module's binary and compares it against
+// what was recorded at install time, so tampering after the fact (rather
+// than at install) is also caught.
+func Verify(name string) error {
 	reg := LoadRegistry()
-	reg[name] = RegistryEntry{
-		BinPath: binPath,
-		Version: manifest.Version,
-		Author:  manifest.Author,
-		Repo:    repo,
+	entry, ok := reg[name]
+	if !ok {
+		return fmt.Errorf("module %q is not installed", name)
+	}
+
+	hash, err := hashFile(entry.BinPath)
+	if err != nil {
+		return fmt.Errorf("hash %s: %w", entry.BinPath, err)
 	}
-	if err := saveRegistry(reg); err != nil {
-		return fmt.Errorf("save registry: %w", err)
+	if hash != entry.BinaryHash {
+		return fmt.Errorf("%s: installed binary hash %s does not match registry entry %s — binary has changed since install", name, hash, entry.BinaryHash)
 	}
 
-	fmt.Printf("  ✓ %s@%s installed → %s\n", name, manifest.Version, binPath)
+	if !entry.Verified {
+		fmt.Printf("  ⚠ %s: hash matches registry, but was recorded (not verified) — installed without a signed manifest.lock\n", name)
+		return nil
+	}
+	fmt.Printf("  ✓ %s: binary matches manifest.lock (%s)\n", name, hash[:12])
 	return nil
 }
 
+// CheckRequires verifies that every required module is installed at a
+// version satisfying its constraint (e.g. "^1.2"), as declared by either a
+// module's manifest.yaml or a pipeline's `requires:` block.
+func CheckRequires(requires map[string]string) error {
+	if len(requires) == 0 {
+		return nil
+	}
+	reg := LoadRegistry()
+	var unmet []string
+	for name, constraint := range requires {
+		entry, ok := reg[name]
+		if !ok {
+			unmet = append(unmet, fmt.Sprintf("%s: not installed (requires %s)", name, constraint))
+			continue
+		}
+		ok, err := satisfies(entry.Version, constraint)
+		if err != nil {
+			unmet = append(unmet, fmt.Sprintf("%s: %v", name, err))
+		} else if !ok {
+			unmet = append(unmet, fmt.Sprintf("%s: installed %s does not satisfy %s", name, entry.Version, constraint))
+		}
+	}
+	if len(unmet) > 0 {
+		return fmt.Errorf("unmet requirements:\n  %s", strings.Join(unmet, "\n  "))
+	}
+	return nil
+}
+
+// satisfies reports whether version meets constraint. A bare version
+// ("1.2.0") is an exact pin; a caret constraint ("^1.2") allows anything
+// with the same major version that is not older.
+func satisfies(version, constraint string) (bool, error) {
+	v := canonicalSemver(version)
+	if !semver.IsValid(v) {
+		return false, fmt.Errorf("installed version %q is not valid semver", version)
+	}
+	constraint = strings.TrimSpace(constraint)
+	caret := strings.HasPrefix(constraint, "^")
+	c := canonicalSemver(strings.TrimPrefix(constraint, "^"))
+	if !semver.IsValid(c) {
+		return false, fmt.Errorf("constraint %q is not valid semver", constraint)
+	}
+	if semver.Compare(v, c) < 0 {
+		return false, nil
+	}
+	if !caret {
+		return semver.Compare(v, c) == 0, nil
+	}
+	return semver.Major(v) == semver.Major(c), nil
+}
+
+func canonicalSemver(v string) string {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	return v
+}
+
+// UpdateResult is what `opentrace update`/`opentrace outdated` report for a
+// single module.
+type UpdateResult struct {
+	Name         string `json:"module"`
+	OldVersion   string `json:"old_version"`
+	NewVersion   string `json:"new_version"`
+	Changed      bool   `json:"changed"`
+	ChangelogURL string `json:"changelog_url,omitempty"`
+}
+
+// Outdated resolves the highest tag satisfying each module's pinned
+// constraint (all installed modules if names is empty) and reports the
+// diff without installing anything.
+func Outdated(names []string) ([]UpdateResult, error) {
+	return planUpdates(names)
+}
+
+// Update resolves and rebuilds every named module (all installed modules if
+// names is empty) whose resolved version has actually changed. Each module's
+// registry entry is reloaded and saved around its own build, rather than all
+// updates sharing one snapshot — otherwise the last save would clobber the
+// Version/BinaryHash/Commit every earlier build in the loop just wrote.
+func Update(names []string, unsafe bool) ([]UpdateResult, error) {
+	results, err := planUpdates(names)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range results {
+		reg := LoadRegistry()
+		entry := reg[r.Name]
+		entry.LatestVersion = r.NewVersion
+		reg[r.Name] = entry
+		if err := saveRegistry(reg); err != nil {
+			return nil, fmt.Errorf("save registry: %w", err)
+		}
+
+		if !r.Changed {
+			continue
+		}
+		_, _, manifest, tmp, err := fetchModuleRef(entry.Repo, r.NewVersion)
+		if err != nil {
+			return nil, fmt.Errorf("update %s: %w", r.Name, err)
+		}
+		newEntry, buildErr := build(r.Name, tmp, manifest, entry.Repo, entry.Constraint, unsafe)
+		os.RemoveAll(tmp)
+		if buildErr != nil {
+			return nil, fmt.Errorf("update %s: %w", r.Name, buildErr)
+		}
+		newEntry.LatestVersion = r.NewVersion
+
+		reg = LoadRegistry()
+		reg[r.Name] = newEntry
+		if err := saveRegistry(reg); err != nil {
+			return nil, fmt.Errorf("save registry: %w", err)
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// planUpdates resolves each module's latest tag against its registry
+// Constraint (if set) without touching anything on disk.
+func planUpdates(names []string) ([]UpdateResult, error) {
+	reg := LoadRegistry()
+	if len(names) == 0 {
+		for name := range reg {
+			names = append(names, name)
+		}
+	}
+
+	var results []UpdateResult
+	for _, name := range names {
+		entry, ok := reg[name]
+		if !ok {
+			return nil, fmt.Errorf("module %q is not installed", name)
+		}
+		tag, err := latestTag(entry.Repo, entry.Constraint)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		results = append(results, UpdateResult{
+			Name:         name,
+			OldVersion:   entry.Version,
+			NewVersion:   tag,
+			Changed:      semver.Compare(canonicalSemver(tag), canonicalSemver(entry.Version)) != 0,
+			ChangelogURL: changelogURL(entry.Repo, tag),
+		})
+	}
+	return results, nil
+}
+
+// latestTag does a shallow `git ls-remote --tags` against repo and returns
+// the highest tag satisfying constraint (or the highest valid semver tag,
+// if constraint is empty).
+func latestTag(repo, constraint string) (string, error) {
+	out, err := exec.Command("git", "ls-remote", "--tags", "--refs", repo).Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote --tags: %w", err)
+	}
+
+	best := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		_, ref, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		tag := strings.TrimPrefix(ref, "refs/tags/")
+		if !semver.IsValid(canonicalSemver(tag)) {
+			continue
+		}
+		if constraint != "" {
+			if ok, _ := satisfies(tag, constraint); !ok {
+				continue
+			}
+		}
+		if best == "" || semver.Compare(canonicalSemver(tag), canonicalSemver(best)) > 0 {
+			best = tag
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no tag in %s satisfies %q", repo, constraint)
+	}
+	return best, nil
+}
+
+// changelogURL guesses a GitHub release URL for a resolved tag; repos
+// hosted elsewhere just get no changelog link.
+func changelogURL(repo, tag string) string {
+	if !strings.Contains(repo, "github.com") {
+		return ""
+	}
+	return strings.TrimSuffix(repo, "/") + "/releases/tag/" + tag
+}
+
+// EmitPR renders update results as the JSON summary a bot can use to open a
+// PR against a shared pipeline repo (module, old→new version, changelog).
+func EmitPR(results []UpdateResult) ([]byte, error) {
+	changed := make([]UpdateResult, 0, len(results))
+	for _, r := range results {
+		if r.Changed {
+			changed = append(changed, r)
+		}
+	}
+	return json.MarshalIndent(changed, "", "  ")
+}
+
 func Uninstall(name string) error {
 	reg := LoadRegistry()
 	entry, ok := reg[name]
@@ -228,12 +906,16 @@ func List() {
 		return
 	}
 	fmt.Println()
-	fmt.Printf("  %-26s  %-10s  %-16s  %s\n", "MODULE", "VERSION", "AUTHOR", "REPO")
-	fmt.Printf("  %-26s  %-10s  %-16s  %s\n",
-		"──────────────────────────", "─────────", "───────────────", "────────────────────────────────")
+	fmt.Printf("  %-26s  %-10s  %-10s  %-16s  %s\n", "MODULE", "VERSION", "LATEST", "AUTHOR", "REPO")
+	fmt.Printf("  %-26s  %-10s  %-10s  %-16s  %s\n",
+		"──────────────────────────", "─────────", "─────────", "───────────────", "────────────────────────────────")
 	for name, entry := range reg {
-		fmt.Printf("  %-26s  %-10s  %-16s  %s\n",
-			name, entry.Version, entry.Author, entry.Repo)
+		latest := entry.LatestVersion
+		if latest == "" {
+			latest = "?"
+		}
+		fmt.Printf("  %-26s  %-10s  %-10s  %-16s  %s\n",
+			name, entry.Version, latest, entry.Author, entry.Repo)
 	}
 	fmt.Println()
 }
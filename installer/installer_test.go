@@ -0,0 +1,128 @@
+package installer
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeLock writes a manifest.lock for srcDir whose fields match the values
+// verifyLock will be called with, so tests can focus on signature handling.
+func writeLock(t *testing.T, srcDir, commit, binaryHash string) {
+	t.Helper()
+	data, err := yaml.Marshal(ManifestLock{
+		Commit:     commit,
+		GoVersion:  goVersionString(),
+		BinaryHash: binaryHash,
+	})
+	if err != nil {
+		t.Fatalf("marshal manifest.lock: %v", err)
+	}
+	if err := os.WriteFile(lockPath(srcDir), data, 0o644); err != nil {
+		t.Fatalf("write manifest.lock: %v", err)
+	}
+}
+
+func TestVerifyLockUnsignedIsRecordedNotVerified(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	srcDir := t.TempDir()
+	writeLock(t, srcDir, "abc123", "deadbeef")
+
+	verified, err := verifyLock(srcDir, "someone", "abc123", "", "deadbeef")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if verified {
+		t.Fatal("an unsigned manifest.lock must not report verified=true")
+	}
+}
+
+func TestVerifyLockSignedByTrustedKeyIsVerified(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	srcDir := t.TempDir()
+	writeLock(t, srcDir, "abc123", "deadbeef")
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if err := os.MkdirAll(trustedKeysDir(), 0o755); err != nil {
+		t.Fatalf("mkdir trusted_keys: %v", err)
+	}
+	keyPath := filepath.Join(trustedKeysDir(), "someone.pub")
+	if err := os.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(pub)), 0o644); err != nil {
+		t.Fatalf("write trusted key: %v", err)
+	}
+
+	lockData, err := os.ReadFile(lockPath(srcDir))
+	if err != nil {
+		t.Fatalf("read manifest.lock: %v", err)
+	}
+	sig := ed25519.Sign(priv, lockData)
+	if err := os.WriteFile(sigPath(srcDir), []byte(base64.StdEncoding.EncodeToString(sig)), 0o644); err != nil {
+		t.Fatalf("write manifest.lock.sig: %v", err)
+	}
+
+	verified, err := verifyLock(srcDir, "someone", "abc123", "", "deadbeef")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !verified {
+		t.Fatal("a manifest.lock signed by a trusted key must report verified=true")
+	}
+}
+
+func TestVerifyLockSignatureMismatchErrors(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	srcDir := t.TempDir()
+	writeLock(t, srcDir, "abc123", "deadbeef")
+
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if err := os.MkdirAll(trustedKeysDir(), 0o755); err != nil {
+		t.Fatalf("mkdir trusted_keys: %v", err)
+	}
+	keyPath := filepath.Join(trustedKeysDir(), "someone.pub")
+	if err := os.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(pub)), 0o644); err != nil {
+		t.Fatalf("write trusted key: %v", err)
+	}
+
+	// Signature from an unrelated key won't verify against the trusted one.
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	lockData, _ := os.ReadFile(lockPath(srcDir))
+	sig := ed25519.Sign(otherPriv, lockData)
+	if err := os.WriteFile(sigPath(srcDir), []byte(base64.StdEncoding.EncodeToString(sig)), 0o644); err != nil {
+		t.Fatalf("write manifest.lock.sig: %v", err)
+	}
+
+	if _, err := verifyLock(srcDir, "someone", "abc123", "", "deadbeef"); err == nil {
+		t.Fatal("expected an error when manifest.lock.sig does not match the trusted key")
+	}
+}
+
+func TestVerifyLockBinaryHashMismatchErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	srcDir := t.TempDir()
+	writeLock(t, srcDir, "abc123", "deadbeef")
+
+	if _, err := verifyLock(srcDir, "someone", "abc123", "", "not-the-built-hash"); err == nil {
+		t.Fatal("expected an error when the built binary's hash does not match manifest.lock")
+	}
+}
+
+func TestVerifyLockMissingIsError(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	srcDir := t.TempDir()
+
+	if _, err := verifyLock(srcDir, "someone", "abc123", "", "deadbeef"); err == nil {
+		t.Fatal("expected an error when manifest.lock is missing")
+	}
+}
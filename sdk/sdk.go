@@ -8,19 +8,36 @@ import (
 
 // Input is what the core sends to a module over stdin.
 type Input struct {
-	Input  string         `json:"input"`
-	Config map[string]any `json:"config"`
+	Input    string         `json:"input"`
+	Entities []Entity       `json:"entities,omitempty"` // upstream entities this module declared it Consumes
+	Config   map[string]any `json:"config"`
+}
+
+// Entity is one piece of structured data a module extracted — an IP, a
+// domain, a person — tagged with its type so downstream modules can filter
+// on it instead of parsing it back out of a stringified Result.
+type Entity struct {
+	Type    string         `json:"type"`
+	ID      string         `json:"id"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+	Sources []string       `json:"sources,omitempty"` // module names that contributed this entity
 }
 
 // Output is what every module must return over stdout.
 type Output struct {
-	Result string `json:"result"` // passed as input to next module if referenced
+	Entities []Entity `json:"entities,omitempty"`
+	Result   string   `json:"result"` // passed as input to next module if referenced
 }
 
 // Module is the interface every module implements.
+//
+// emit lets a long-running module report entities as it finds them instead
+// of waiting until Run returns — the core reads these incrementally off the
+// module's stdout, one JSON object per line. Calling it is optional; a
+// module that only has a final answer can just populate Output.Entities.
 type Module interface {
 	Name() string
-	Run(input Input) (Output, error)
+	Run(input Input, emit func(Entity)) (Output, error)
 }
 
 // Run is called in every module's main().
@@ -32,13 +49,21 @@ func Run(m Module) {
 		os.Exit(1)
 	}
 
-	out, err := m.Run(in)
+	// Newline-delimited JSON on stdout: each Encode call writes one object
+	// followed by "\n", so the core can parse entities as they're emitted
+	// instead of buffering the whole run into a single JSON document.
+	enc := json.NewEncoder(os.Stdout)
+	emit := func(e Entity) {
+		_ = enc.Encode(Output{Entities: []Entity{e}})
+	}
+
+	out, err := m.Run(in, emit)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[%s] error: %v\n", m.Name(), err)
 		os.Exit(1)
 	}
 
-	if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+	if err := enc.Encode(out); err != nil {
 		fmt.Fprintf(os.Stderr, "[%s] encode output: %v\n", m.Name(), err)
 		os.Exit(1)
 	}